@@ -0,0 +1,239 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// c4Package accumulates the elements of a single proto package, which is
+// modelled as a C4 container.
+type c4Package struct {
+	name     string
+	services []string
+	messages []string
+}
+
+// c4Relationship is a relationship between two elements, each identified by
+// its kind and fully qualified name.
+type c4Relationship struct {
+	from, to   [2]string
+	desc, tech string
+}
+
+// generateC4Model produces a Structurizr DSL fragment modelling the request in C4
+// terms.  Each proto package becomes a container, holding its services and
+// messages as components.  Methods become relationships from a service to its
+// request and response messages, and imports become relationships between the
+// containers of the importing and imported packages.
+//
+// The fragment is intended to be included within the model block of a workspace.
+func generateC4Model(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse_File, error) {
+	idx := newDescriptorIndex(req)
+
+	packages := map[string]*c4Package{}
+	pkg := func(name string) *c4Package {
+		if _, ok := packages[name]; !ok {
+			packages[name] = &c4Package{name: name}
+		}
+		return packages[name]
+	}
+	included := map[string]bool{}
+	addMessage := func(fqn string) {
+		mi, ok := idx.messages[fqn]
+		if !ok || included[fqn] {
+			return
+		}
+		included[fqn] = true
+		p := pkg(mi.file.GetPackage())
+		p.messages = append(p.messages, fqn)
+	}
+
+	var rels []c4Relationship
+	imports := map[[2]string][]string{}
+	// imported records the deps already listed for each pair of packages, as
+	// several files of a package may import the same file.
+	imported := map[[2]string]map[string]bool{}
+	for _, name := range req.GetFileToGenerate() {
+		f, ok := idx.files[name]
+		if !ok {
+			return nil, fmt.Errorf("file to generate %q not present in request", name)
+		}
+		p := pkg(f.GetPackage())
+		for _, m := range f.GetMessageType() {
+			for _, mi := range idx.localMessages(m, packagePrefix(f), packagePrefix(f)) {
+				if !mi.desc.GetOptions().GetMapEntry() {
+					addMessage(mi.fqn)
+				}
+			}
+		}
+
+		for _, srv := range f.GetService() {
+			qService := packagePrefix(f) + "." + srv.GetName()
+			p.services = append(p.services, qService)
+			for _, meth := range srv.GetMethod() {
+				// messages from other files are only modelled when a method uses them.
+				addMessage(meth.GetInputType())
+				addMessage(meth.GetOutputType())
+				inDesc, outDesc := meth.GetName()+" request", meth.GetName()+" response"
+				if meth.GetClientStreaming() {
+					inDesc = inDesc + " (stream)"
+				}
+				if meth.GetServerStreaming() {
+					outDesc = outDesc + " (stream)"
+				}
+				rels = append(rels,
+					c4Relationship{[2]string{"svc", qService}, [2]string{"msg", meth.GetInputType()}, inDesc, "gRPC"},
+					c4Relationship{[2]string{"svc", qService}, [2]string{"msg", meth.GetOutputType()}, outDesc, "gRPC"},
+				)
+			}
+		}
+
+		for _, dep := range f.GetDependency() {
+			df, ok := idx.files[dep]
+			if !ok || df.GetPackage() == f.GetPackage() {
+				continue
+			}
+			pkg(df.GetPackage())
+			key := [2]string{f.GetPackage(), df.GetPackage()}
+			if imported[key] == nil {
+				imported[key] = map[string]bool{}
+			}
+			if imported[key][dep] {
+				continue
+			}
+			imported[key][dep] = true
+			imports[key] = append(imports[key], dep)
+		}
+	}
+
+	var importKeys [][2]string
+	for key := range imports {
+		importKeys = append(importKeys, key)
+	}
+	sort.Slice(importKeys, func(i, j int) bool {
+		if importKeys[i][0] != importKeys[j][0] {
+			return importKeys[i][0] < importKeys[j][0]
+		}
+		return importKeys[i][1] < importKeys[j][1]
+	})
+	for _, key := range importKeys {
+		rels = append(rels, c4Relationship{
+			[2]string{"pkg", key[0]}, [2]string{"pkg", key[1]}, "imports " + strings.Join(imports[key], ", "), "protobuf import",
+		})
+	}
+
+	var names []string
+	for name := range packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// identifiers are allocated as elements are written, so relationships are
+	// written last.
+	ids := newC4Identifiers()
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "// Structurizr DSL fragment describing protobuf APIs as a C4 model.")
+	fmt.Fprintln(buf, "// Include it within the model block of a workspace, e.g. !include c4_model.dsl")
+	fmt.Fprintln(buf, "protobufApis = softwareSystem \"Protobuf APIs\" \"Services and messages defined in protobuf.\" {")
+	for _, name := range names {
+		p := packages[name]
+		display := p.name
+		if display == "" {
+			display = "(default package)"
+		}
+		fmt.Fprintf(buf, "    %s = container %q %q \"Protocol Buffers\" {\n", ids.id("pkg", p.name), display, "Protobuf package "+display+".")
+		fmt.Fprintln(buf, "        tags \"Protobuf Package\"")
+		for _, qService := range p.services {
+			fmt.Fprintf(buf, "        %s = component %q %q \"gRPC service\" {\n", ids.id("svc", qService), c4LocalName(p.name, qService), strings.TrimPrefix(qService, "."))
+			fmt.Fprintln(buf, "            tags \"gRPC Service\"")
+			fmt.Fprintln(buf, "        }")
+		}
+		for _, qMessage := range p.messages {
+			fmt.Fprintf(buf, "        %s = component %q %q \"Protobuf message\" {\n", ids.id("msg", qMessage), c4LocalName(p.name, qMessage), strings.TrimPrefix(qMessage, "."))
+			fmt.Fprintln(buf, "            tags \"Data\"")
+			fmt.Fprintln(buf, "        }")
+		}
+		fmt.Fprintln(buf, "    }")
+	}
+	if len(rels) > 0 {
+		fmt.Fprintln(buf)
+	}
+	for _, r := range rels {
+		fmt.Fprintf(buf, "    %s -> %s %q %q\n", ids.id(r.from[0], r.from[1]), ids.id(r.to[0], r.to[1]), r.desc, r.tech)
+	}
+	fmt.Fprintln(buf, "}")
+
+	return &pluginpb.CodeGeneratorResponse_File{
+		Name:    proto.String("c4_model.dsl"),
+		Content: proto.String(buf.String()),
+	}, nil
+}
+
+// c4Identifiers allocates Structurizr identifiers for elements.  Identifiers
+// share a single namespace, so they are qualified with the element kind, and
+// names which sanitize to the same identifier, such as a.b_c and a_b.c, are
+// distinguished with a numeric suffix in the order they are first seen.
+type c4Identifiers struct {
+	// ids maps an element kind and fully qualified name to its identifier.
+	ids  map[[2]string]string
+	used map[string]bool
+}
+
+func newC4Identifiers() *c4Identifiers {
+	return &c4Identifiers{
+		ids:  make(map[[2]string]string),
+		used: make(map[string]bool),
+	}
+}
+
+// id returns the identifier for an element, allocating it on first use.
+func (c *c4Identifiers) id(kind, fqn string) string {
+	key := [2]string{kind, fqn}
+	if id, ok := c.ids[key]; ok {
+		return id
+	}
+	name := strings.TrimPrefix(fqn, ".")
+	if name == "" {
+		name = "default"
+	}
+	base := kind + "_" + strings.Map(func(r rune) rune {
+		if r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+	id := base
+	for n := 2; c.used[id]; n++ {
+		id = fmt.Sprintf("%s_%d", base, n)
+	}
+	c.ids[key] = id
+	c.used[id] = true
+	return id
+}
+
+// c4LocalName returns the name of an element relative to its package.
+func c4LocalName(pkg, fqn string) string {
+	if pkg == "" {
+		return strings.TrimPrefix(fqn, ".")
+	}
+	return strings.TrimPrefix(fqn, "."+pkg+".")
+}
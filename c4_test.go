@@ -0,0 +1,141 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+var (
+	c4ElementLine      = regexp.MustCompile(`^\s*(\w+) = (?:container|component) "([^"]*)" "([^"]*)"`)
+	c4RelationshipLine = regexp.MustCompile(`^\s*(\w+) -> (\w+) "([^"]*)"`)
+)
+
+func TestC4Model(t *testing.T) {
+	file := func(name, pkg string, deps ...string) *descriptorpb.FileDescriptorProto {
+		return &descriptorpb.FileDescriptorProto{
+			Name:       proto.String(name),
+			Package:    proto.String(pkg),
+			Dependency: deps,
+		}
+	}
+	message := func(f *descriptorpb.FileDescriptorProto, name string) *descriptorpb.FileDescriptorProto {
+		f.MessageType = append(f.MessageType, &descriptorpb.DescriptorProto{Name: proto.String(name)})
+		return f
+	}
+
+	// a.b_c and a_b.c, and the packages foo.bar and foo_bar, sanitize to the same identifiers.
+	svc := file("foo/svc.proto", "foo.bar", "a.proto", "a_b.proto")
+	svc.Service = []*descriptorpb.ServiceDescriptorProto{{
+		Name: proto.String("S"),
+		Method: []*descriptorpb.MethodDescriptorProto{{
+			Name:       proto.String("M"),
+			InputType:  proto.String(".a.b_c"),
+			OutputType: proto.String(".a_b.c"),
+		}},
+	}}
+	req := &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"foo/svc.proto", "foo/other.proto", "foo_bar.proto"},
+		ProtoFile: []*descriptorpb.FileDescriptorProto{
+			message(file("a.proto", "a"), "b_c"),
+			message(file("a_b.proto", "a_b"), "c"),
+			svc,
+			// a second file of foo.bar importing a.proto must not list it twice.
+			message(file("foo/other.proto", "foo.bar", "a.proto"), "Other"),
+			message(file("foo_bar.proto", "foo_bar", "a.proto"), "Other"),
+		},
+	}
+
+	f, err := generateC4Model(req)
+	if err != nil {
+		t.Fatalf("generateC4Model: %v", err)
+	}
+	content := f.GetContent()
+	defer func() {
+		if t.Failed() {
+			t.Logf("c4_model.dsl:\n%s", content)
+		}
+	}()
+
+	// ids maps each identifier to the fully qualified name of its element.
+	ids := map[string]string{}
+	byName := map[string]string{}
+	rels := map[string]bool{}
+	for _, line := range strings.Split(content, "\n") {
+		if m := c4ElementLine.FindStringSubmatch(line); m != nil {
+			id, fqn := m[1], m[3]
+			if strings.HasPrefix(m[3], "Protobuf package") {
+				fqn = "package " + m[2]
+			}
+			if other, ok := ids[id]; ok {
+				t.Errorf("identifier %s is used by both %s and %s", id, other, fqn)
+			}
+			ids[id] = fqn
+			byName[fqn] = id
+			continue
+		}
+		if m := c4RelationshipLine.FindStringSubmatch(line); m != nil {
+			if rels[line] {
+				t.Errorf("duplicate relationship %s", strings.TrimSpace(line))
+			}
+			rels[line] = true
+			for _, id := range m[1:3] {
+				if _, ok := ids[id]; !ok {
+					t.Errorf("relationship %s refers to undefined identifier %s", strings.TrimSpace(line), id)
+				}
+			}
+			if strings.HasPrefix(m[3], "imports ") {
+				seen := map[string]bool{}
+				for _, dep := range strings.Split(strings.TrimPrefix(m[3], "imports "), ", ") {
+					if seen[dep] {
+						t.Errorf("relationship %s lists %s twice", strings.TrimSpace(line), dep)
+					}
+					seen[dep] = true
+				}
+			}
+		}
+	}
+
+	for _, pair := range [][2]string{
+		{"a.b_c", "a_b.c"},
+		{"package foo.bar", "package foo_bar"},
+	} {
+		first, ok1 := byName[pair[0]]
+		second, ok2 := byName[pair[1]]
+		if !ok1 || !ok2 {
+			t.Errorf("missing elements for %s and %s", pair[0], pair[1])
+			continue
+		}
+		if first == second {
+			t.Errorf("%s and %s share the identifier %s", pair[0], pair[1], first)
+		}
+	}
+
+	for _, want := range []string{
+		byName["foo.bar.S"] + " -> " + byName["a.b_c"] + ` "M request"`,
+		byName["foo.bar.S"] + " -> " + byName["a_b.c"] + ` "M response"`,
+		byName["package foo.bar"] + " -> " + byName["package a"] + ` "imports a.proto"`,
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("c4_model.dsl is missing relationship %s", want)
+		}
+	}
+}
@@ -0,0 +1,847 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// Field numbers within descriptor.proto, used to build source code info paths.
+const (
+	filePackagePath   = 2
+	fileImportPath    = 3
+	fileMessagePath   = 4
+	fileEnumPath      = 5
+	fileServicePath   = 6
+	fileExtensionPath = 7
+	messageFieldPath  = 2
+	messageNestedPath = 3
+	messageEnumPath   = 4
+	messageExtPath    = 6
+	messageOneofPath  = 8
+	enumValuePath     = 2
+	serviceMethodPath = 2
+	fileSyntaxPath    = 12
+)
+
+const (
+	// maxFieldNumber and maxEnumNumber are the values "max" stands for in ranges.
+	maxFieldNumber = 536870911
+	maxEnumNumber  = math.MaxInt32
+
+	editionsOutputDir   = "editions"
+	editionsReportFile  = "editions_migration_report.md"
+	editionsIndentation = "  "
+)
+
+// generateEditionsMigration rewrites each proto2 and proto3 file to generate as
+// an equivalent edition 2023 source file, along with a report describing the
+// feature overrides applied and anything that needs a human to look at it.
+func generateEditionsMigration(req *pluginpb.CodeGeneratorRequest) ([]*pluginpb.CodeGeneratorResponse_File, error) {
+	idx := newDescriptorIndex(req)
+	resolver := newOptionResolver(req)
+
+	var out []*pluginpb.CodeGeneratorResponse_File
+	report := new(bytes.Buffer)
+	fmt.Fprintln(report, "# editions migration report")
+	for _, name := range req.GetFileToGenerate() {
+		f, ok := idx.files[name]
+		if !ok {
+			return nil, fmt.Errorf("file to generate %q not present in request", name)
+		}
+		fmt.Fprintf(report, "\n## %s\n\n", name)
+
+		p := &editionsPrinter{
+			buf:      new(bytes.Buffer),
+			idx:      idx,
+			resolver: resolver,
+			file:     f,
+			proto3:   f.GetSyntax() == "proto3",
+			comments: make(map[string][]*descriptorpb.SourceCodeInfo_Location),
+		}
+		for _, loc := range f.GetSourceCodeInfo().GetLocation() {
+			if hasComments(loc) {
+				key := pathKey(loc.GetPath())
+				p.comments[key] = append(p.comments[key], loc)
+			}
+		}
+		p.printFile()
+		p.reviewDroppedComments()
+
+		outName := editionsOutputDir + "/" + name
+		out = append(out, &pluginpb.CodeGeneratorResponse_File{
+			Name:    proto.String(outName),
+			Content: proto.String(p.buf.String()),
+		})
+
+		syntax := "proto2"
+		if p.proto3 {
+			syntax = "proto3"
+		}
+		fmt.Fprintf(report, "Migrated from %s to edition 2023, written to `%s`.\n\n", syntax, outName)
+		fmt.Fprintln(report, "### changes")
+		fmt.Fprintln(report)
+		for _, c := range p.changes {
+			fmt.Fprintf(report, "- %s\n", c)
+		}
+		fmt.Fprintln(report)
+		fmt.Fprintln(report, "### needs manual review")
+		fmt.Fprintln(report)
+		if len(p.reviews) == 0 {
+			fmt.Fprintln(report, "Nothing flagged.")
+		}
+		for _, r := range p.reviews {
+			fmt.Fprintf(report, "- %s\n", r)
+		}
+	}
+
+	out = append(out, &pluginpb.CodeGeneratorResponse_File{
+		Name:    proto.String(editionsReportFile),
+		Content: proto.String(report.String()),
+	})
+	return out, nil
+}
+
+// newOptionResolver builds a resolver for the extensions declared in the request,
+// so custom options can be decoded rather than left as unknown fields.  Files the
+// Go runtime rejects, along with anything depending on them, are left out; options
+// they declare are reported as unresolved.
+func newOptionResolver(req *pluginpb.CodeGeneratorRequest) *dynamicpb.Types {
+	files := new(protoregistry.Files)
+	// protoc lists files in topological order, so dependencies are always registered first.
+	for _, fdp := range req.GetProtoFile() {
+		if fd, err := protodesc.NewFile(fdp, files); err == nil {
+			files.RegisterFile(fd)
+		}
+	}
+	return dynamicpb.NewTypes(files)
+}
+
+// editionsPrinter writes a single file as edition 2023 source, recording the
+// semantic changes it makes along the way.
+type editionsPrinter struct {
+	buf      *bytes.Buffer
+	idx      *descriptorIndex
+	resolver *dynamicpb.Types
+	file     *descriptorpb.FileDescriptorProto
+	proto3   bool
+	depth    int
+	// comments holds the source locations with comments which have not been
+	// written yet, keyed by source code info path.
+	comments map[string][]*descriptorpb.SourceCodeInfo_Location
+
+	changes []string
+	reviews []string
+}
+
+// pathKey converts a source code info path into a map key.
+func pathKey(path []int32) string {
+	parts := make([]string, len(path))
+	for i, p := range path {
+		parts[i] = strconv.Itoa(int(p))
+	}
+	return strings.Join(parts, ",")
+}
+
+// appendPath returns a copy of path extended with elems, so sibling paths never
+// share a backing array.
+func appendPath(path []int32, elems ...int32) []int32 {
+	out := make([]int32, 0, len(path)+len(elems))
+	return append(append(out, path...), elems...)
+}
+
+// line writes a single indented line.
+func (p *editionsPrinter) line(format string, args ...interface{}) {
+	if format == "" {
+		fmt.Fprintln(p.buf)
+		return
+	}
+	fmt.Fprint(p.buf, strings.Repeat(editionsIndentation, p.depth))
+	fmt.Fprintf(p.buf, format, args...)
+	fmt.Fprintln(p.buf)
+}
+
+// hasComments reports whether any comments are attached to the location.
+func hasComments(loc *descriptorpb.SourceCodeInfo_Location) bool {
+	return loc.LeadingComments != nil || loc.TrailingComments != nil || len(loc.GetLeadingDetachedComments()) > 0
+}
+
+// comment writes the detached and leading comments of the next location
+// recorded for path, returning the location so its trailing comment can be
+// written after the declaration.
+func (p *editionsPrinter) comment(path []int32) *descriptorpb.SourceCodeInfo_Location {
+	key := pathKey(path)
+	locs := p.comments[key]
+	if len(locs) == 0 {
+		return nil
+	}
+	loc := locs[0]
+	if len(locs) == 1 {
+		delete(p.comments, key)
+	} else {
+		p.comments[key] = locs[1:]
+	}
+	for _, c := range loc.GetLeadingDetachedComments() {
+		p.commentLines(c)
+		p.line("")
+	}
+	if loc.LeadingComments != nil {
+		p.commentLines(loc.GetLeadingComments())
+	}
+	return loc
+}
+
+// commentLines writes the text of a single comment as line comments.
+func (p *editionsPrinter) commentLines(c string) {
+	for _, l := range strings.Split(strings.TrimSuffix(c, "\n"), "\n") {
+		p.line("//%s", l)
+	}
+}
+
+// trailing returns the trailing comment of loc, formatted to follow a
+// declaration on the same line.  Comments spanning several lines are flagged for
+// review instead.
+func (p *editionsPrinter) trailing(loc *descriptorpb.SourceCodeInfo_Location) string {
+	if loc == nil || loc.TrailingComments == nil {
+		return ""
+	}
+	c := strings.TrimSuffix(loc.GetTrailingComments(), "\n")
+	if strings.Contains(c, "\n") {
+		p.review("The trailing comment at line %d of the original file spans several lines, so it was not carried over.", loc.GetSpan()[0]+1)
+		return ""
+	}
+	return " //" + c
+}
+
+// reviewDroppedComments flags the comments which were never written, such as
+// those attached to options or reserved ranges.
+func (p *editionsPrinter) reviewDroppedComments() {
+	var lines []int
+	for _, locs := range p.comments {
+		for _, loc := range locs {
+			lines = append(lines, int(loc.GetSpan()[0])+1)
+		}
+	}
+	sort.Ints(lines)
+	for _, l := range lines {
+		p.review("Comments at line %d of the original file were not carried over.", l)
+	}
+}
+
+func (p *editionsPrinter) change(format string, args ...interface{}) {
+	p.changes = append(p.changes, fmt.Sprintf(format, args...))
+}
+
+func (p *editionsPrinter) review(format string, args ...interface{}) {
+	p.reviews = append(p.reviews, fmt.Sprintf(format, args...))
+}
+
+// printFile writes the file header, followed by all top level declarations.
+func (p *editionsPrinter) printFile() {
+	f := p.file
+	// comments on the syntax statement usually hold the license header.
+	loc := p.comment([]int32{fileSyntaxPath})
+	p.line("edition = \"2023\";%s", p.trailing(loc))
+	if f.GetPackage() != "" {
+		p.line("")
+		loc := p.comment([]int32{filePackagePath})
+		p.line("package %s;%s", f.GetPackage(), p.trailing(loc))
+	}
+
+	if len(f.GetDependency()) > 0 {
+		p.line("")
+	}
+	public, weak := map[int32]bool{}, map[int32]bool{}
+	for _, i := range f.GetPublicDependency() {
+		public[i] = true
+	}
+	for _, i := range f.GetWeakDependency() {
+		weak[i] = true
+	}
+	for i, dep := range f.GetDependency() {
+		loc := p.comment([]int32{fileImportPath, int32(i)})
+		switch {
+		case public[int32(i)]:
+			p.line("import public %q;%s", dep, p.trailing(loc))
+		case weak[int32(i)]:
+			p.line("import weak %q;%s", dep, p.trailing(loc))
+			p.review("`%s` is a weak import; weak imports are deprecated and are not supported by every runtime.", dep)
+		default:
+			p.line("import %q;%s", dep, p.trailing(loc))
+		}
+	}
+
+	opts := p.options(f.GetOptions(), "file "+f.GetName(), "features")
+	if p.proto3 {
+		// proto3 fields without a label have implicit presence; every other proto3
+		// behaviour matches the edition 2023 defaults.
+		opts = append(opts, "features.field_presence = IMPLICIT")
+		p.change("file: `features.field_presence = IMPLICIT`, as proto3 singular scalar fields do not track presence.")
+	} else {
+		opts = append(opts,
+			"features.enum_type = CLOSED",
+			"features.repeated_field_encoding = EXPANDED",
+			"features.utf8_validation = NONE",
+			"features.json_format = LEGACY_BEST_EFFORT",
+		)
+		p.change("file: `features.enum_type = CLOSED`, as proto2 enums are closed.")
+		p.change("file: `features.repeated_field_encoding = EXPANDED`, as proto2 repeated scalars are unpacked unless marked `[packed = true]`.")
+		p.change("file: `features.utf8_validation = NONE`, as proto2 does not validate string fields.")
+		p.change("file: `features.json_format = LEGACY_BEST_EFFORT`, as proto2 does not enforce JSON name conflicts.")
+	}
+	p.line("")
+	for _, o := range opts {
+		p.line("option %s;", o)
+	}
+
+	prefix := packagePrefix(f)
+	for i, e := range f.GetEnumType() {
+		p.line("")
+		p.printEnum(e, prefix, []int32{fileEnumPath, int32(i)})
+	}
+	for i, m := range f.GetMessageType() {
+		p.line("")
+		p.printMessage(m, prefix, []int32{fileMessagePath, int32(i)})
+	}
+	p.printExtensions(f.GetExtension(), prefix, []int32{fileExtensionPath})
+	for i, s := range f.GetService() {
+		p.line("")
+		p.printService(s, prefix, []int32{fileServicePath, int32(i)})
+	}
+}
+
+// printMessage writes a message declaration and everything nested within it.
+func (p *editionsPrinter) printMessage(dp *descriptorpb.DescriptorProto, prefix string, path []int32) {
+	fqn := prefix + "." + dp.GetName()
+	loc := p.comment(path)
+	p.line("message %s {%s", dp.GetName(), p.trailing(loc))
+	p.depth++
+	for _, o := range p.options(dp.GetOptions(), "message "+strings.TrimPrefix(fqn, "."), "features") {
+		p.line("option %s;", o)
+	}
+
+	printedOneof := map[int32]bool{}
+	for i, fd := range dp.GetField() {
+		if fd.OneofIndex == nil || fd.GetProto3Optional() {
+			p.printField(fd, fqn, appendPath(path, messageFieldPath, int32(i)))
+			continue
+		}
+		// a oneof is written out in full where its first member is declared.
+		oi := fd.GetOneofIndex()
+		if printedOneof[oi] {
+			continue
+		}
+		printedOneof[oi] = true
+		od := dp.GetOneofDecl()[oi]
+		loc := p.comment(appendPath(path, messageOneofPath, oi))
+		p.line("oneof %s {%s", od.GetName(), p.trailing(loc))
+		p.depth++
+		for _, o := range p.options(od.GetOptions(), "oneof "+strings.TrimPrefix(fqn, ".")+"."+od.GetName(), "features") {
+			p.line("option %s;", o)
+		}
+		for j, member := range dp.GetField() {
+			if member.OneofIndex != nil && member.GetOneofIndex() == oi {
+				p.printField(member, fqn, appendPath(path, messageFieldPath, int32(j)))
+			}
+		}
+		p.depth--
+		p.line("}")
+	}
+
+	for i, nested := range dp.GetNestedType() {
+		if nested.GetOptions().GetMapEntry() {
+			// map entries are implied by the map field.
+			continue
+		}
+		p.line("")
+		p.printMessage(nested, fqn, appendPath(path, messageNestedPath, int32(i)))
+	}
+	for i, e := range dp.GetEnumType() {
+		p.line("")
+		p.printEnum(e, fqn, appendPath(path, messageEnumPath, int32(i)))
+	}
+	p.printExtensions(dp.GetExtension(), fqn, appendPath(path, messageExtPath))
+
+	if len(dp.GetExtensionRange()) > 0 {
+		p.line("")
+	}
+	for _, er := range dp.GetExtensionRange() {
+		r := formatRange(er.GetStart(), er.GetEnd()-1, maxFieldNumber)
+		opts := p.options(er.GetOptions(), "extension range "+r+" of "+strings.TrimPrefix(fqn, "."), "features")
+		p.line("extensions %s%s;", r, bracketOptions(opts))
+	}
+	var reserved []string
+	for _, rr := range dp.GetReservedRange() {
+		reserved = append(reserved, formatRange(rr.GetStart(), rr.GetEnd()-1, maxFieldNumber))
+	}
+	p.printReserved(reserved, dp.GetReservedName(), strings.TrimPrefix(fqn, "."))
+
+	p.depth--
+	p.line("}")
+}
+
+// printField writes a single field declaration, translating labels and legacy
+// options into the equivalent feature overrides.
+func (p *editionsPrinter) printField(fd *descriptorpb.FieldDescriptorProto, scope string, path []int32) {
+	name := strings.TrimPrefix(scope, ".") + "." + fd.GetName()
+	if fd.GetExtendee() != "" {
+		name = "extension " + name
+	}
+	repeated := fd.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+
+	typ := fieldTypeName(fd)
+	if fd.GetTypeName() != "" {
+		typ = fd.GetTypeName()
+	}
+	label := ""
+	if entry, ok := p.idx.mapEntry(fd); ok {
+		key, val := entry.GetField()[0], entry.GetField()[1]
+		valType := fieldTypeName(val)
+		if val.GetTypeName() != "" {
+			valType = val.GetTypeName()
+		}
+		typ = fmt.Sprintf("map<%s, %s>", fieldTypeName(key), valType)
+	} else if repeated {
+		label = "repeated "
+	}
+
+	var opts []string
+	if fd.DefaultValue != nil {
+		opts = append(opts, "default = "+formatDefault(fd))
+	}
+	if fd.JsonName != nil && fd.GetJsonName() != jsonCamelCase(fd.GetName()) {
+		opts = append(opts, fmt.Sprintf("json_name = %s", protoQuote(fd.GetJsonName())))
+	}
+
+	switch {
+	case fd.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REQUIRED:
+		opts = append(opts, "features.field_presence = LEGACY_REQUIRED")
+		p.change("`%s`: `required` label replaced by `features.field_presence = LEGACY_REQUIRED`.", name)
+	case fd.GetProto3Optional() && isMessageField(fd):
+		p.change("`%s`: `optional` label dropped, message fields always track presence.", name)
+	case fd.GetProto3Optional():
+		opts = append(opts, "features.field_presence = EXPLICIT")
+		p.change("`%s`: `optional` label replaced by `features.field_presence = EXPLICIT`.", name)
+	}
+
+	if fd.GetType() == descriptorpb.FieldDescriptorProto_TYPE_GROUP {
+		opts = append(opts, "features.message_encoding = DELIMITED")
+		p.change("`%s`: group replaced by a field of type `%s` with `features.message_encoding = DELIMITED`.", name, strings.TrimPrefix(fd.GetTypeName(), "."))
+		p.review("`%s` was a group. Its wire format is unchanged, but confirm text format and JSON consumers still accept the field name `%s`.", name, fd.GetName())
+	}
+
+	if repeated && isPackable(fd.GetType()) && fd.GetOptions() != nil && fd.GetOptions().Packed != nil {
+		packed := fd.GetOptions().GetPacked()
+		switch {
+		case packed && !p.proto3:
+			opts = append(opts, "features.repeated_field_encoding = PACKED")
+			p.change("`%s`: `[packed = true]` replaced by `features.repeated_field_encoding = PACKED`.", name)
+		case !packed && p.proto3:
+			opts = append(opts, "features.repeated_field_encoding = EXPANDED")
+			p.change("`%s`: `[packed = false]` replaced by `features.repeated_field_encoding = EXPANDED`.", name)
+		default:
+			p.change("`%s`: redundant `packed` option dropped, it matches the file default.", name)
+		}
+	}
+
+	if fd.GetType() == descriptorpb.FieldDescriptorProto_TYPE_ENUM && !p.proto3 {
+		if ef, ok := p.idx.enumFiles[fd.GetTypeName()]; ok && ef.GetSyntax() == "proto3" {
+			p.review("`%s` uses the open enum `%s` from a proto2 file. Runtimes disagree on how proto2 fields treat unknown values of open enums, so confirm the migrated behaviour.", name, strings.TrimPrefix(fd.GetTypeName(), "."))
+		}
+	}
+
+	opts = append(p.options(fd.GetOptions(), "field "+name, "packed", "features"), opts...)
+	loc := p.comment(path)
+	p.line("%s%s %s = %d%s;%s", label, typ, fd.GetName(), fd.GetNumber(), bracketOptions(opts), p.trailing(loc))
+}
+
+// printExtensions writes extension declarations grouped by extendee, in the
+// order each extendee is first seen.
+func (p *editionsPrinter) printExtensions(exts []*descriptorpb.FieldDescriptorProto, scope string, path []int32) {
+	var extendees []string
+	byExtendee := map[string][]int{}
+	for i, x := range exts {
+		if _, ok := byExtendee[x.GetExtendee()]; !ok {
+			extendees = append(extendees, x.GetExtendee())
+		}
+		byExtendee[x.GetExtendee()] = append(byExtendee[x.GetExtendee()], i)
+	}
+	for _, extendee := range extendees {
+		p.line("")
+		p.line("extend %s {", extendee)
+		p.depth++
+		for _, i := range byExtendee[extendee] {
+			p.printField(exts[i], scope, appendPath(path, int32(i)))
+		}
+		p.depth--
+		p.line("}")
+	}
+}
+
+// printEnum writes an enum declaration.
+func (p *editionsPrinter) printEnum(ed *descriptorpb.EnumDescriptorProto, prefix string, path []int32) {
+	fqn := strings.TrimPrefix(prefix+"."+ed.GetName(), ".")
+	loc := p.comment(path)
+	p.line("enum %s {%s", ed.GetName(), p.trailing(loc))
+	p.depth++
+	for _, o := range p.options(ed.GetOptions(), "enum "+fqn, "features") {
+		p.line("option %s;", o)
+	}
+	for i, v := range ed.GetValue() {
+		opts := p.options(v.GetOptions(), "enum value "+fqn+"."+v.GetName(), "features")
+		loc := p.comment(appendPath(path, enumValuePath, int32(i)))
+		p.line("%s = %d%s;%s", v.GetName(), v.GetNumber(), bracketOptions(opts), p.trailing(loc))
+	}
+	var reserved []string
+	for _, rr := range ed.GetReservedRange() {
+		// unlike messages, enum reserved ranges are inclusive.
+		reserved = append(reserved, formatRange(rr.GetStart(), rr.GetEnd(), maxEnumNumber))
+	}
+	p.printReserved(reserved, ed.GetReservedName(), fqn)
+	p.depth--
+	p.line("}")
+}
+
+// printReserved writes reserved ranges and names.  Editions reserve names as
+// identifiers rather than string literals.
+func (p *editionsPrinter) printReserved(ranges, names []string, scope string) {
+	if len(ranges) == 0 && len(names) == 0 {
+		return
+	}
+	p.line("")
+	if len(ranges) > 0 {
+		p.line("reserved %s;", strings.Join(ranges, ", "))
+	}
+	if len(names) > 0 {
+		p.line("reserved %s;", strings.Join(names, ", "))
+		p.change("`%s`: reserved names written as identifiers rather than strings.", scope)
+	}
+}
+
+// printService writes a service and its methods.
+func (p *editionsPrinter) printService(sd *descriptorpb.ServiceDescriptorProto, prefix string, path []int32) {
+	fqn := strings.TrimPrefix(prefix+"."+sd.GetName(), ".")
+	loc := p.comment(path)
+	p.line("service %s {%s", sd.GetName(), p.trailing(loc))
+	p.depth++
+	for _, o := range p.options(sd.GetOptions(), "service "+fqn, "features") {
+		p.line("option %s;", o)
+	}
+	for i, m := range sd.GetMethod() {
+		in, out := m.GetInputType(), m.GetOutputType()
+		if m.GetClientStreaming() {
+			in = "stream " + in
+		}
+		if m.GetServerStreaming() {
+			out = "stream " + out
+		}
+		loc := p.comment(appendPath(path, serviceMethodPath, int32(i)))
+		opts := p.options(m.GetOptions(), "method "+fqn+"."+m.GetName(), "features")
+		if len(opts) == 0 {
+			p.line("rpc %s(%s) returns (%s);%s", m.GetName(), in, out, p.trailing(loc))
+			continue
+		}
+		p.line("rpc %s(%s) returns (%s) {%s", m.GetName(), in, out, p.trailing(loc))
+		p.depth++
+		for _, o := range opts {
+			p.line("option %s;", o)
+		}
+		p.depth--
+		p.line("}")
+	}
+	p.depth--
+	p.line("}")
+}
+
+// options returns "name = value" assignments for every option set on opts,
+// ordered by field number with extensions last.  Custom options are decoded
+// using the extensions present in the request; any which cannot be decoded
+// are flagged for review.
+func (p *editionsPrinter) options(opts proto.Message, where string, skip ...string) []string {
+	if opts == nil || !opts.ProtoReflect().IsValid() {
+		return nil
+	}
+	m := opts.ProtoReflect()
+	if len(m.GetUnknown()) > 0 {
+		b, err := proto.Marshal(opts)
+		if err == nil {
+			resolved := m.New().Interface()
+			if err := (proto.UnmarshalOptions{Resolver: p.resolver}).Unmarshal(b, resolved); err == nil {
+				m = resolved.ProtoReflect()
+			}
+		}
+	}
+	if len(m.GetUnknown()) > 0 {
+		p.review("Options on %s could not be decoded, so they were not carried over.", where)
+	}
+
+	type setField struct {
+		fd protoreflect.FieldDescriptor
+		v  protoreflect.Value
+	}
+	var fields []setField
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		for _, s := range skip {
+			if !fd.IsExtension() && string(fd.Name()) == s {
+				return true
+			}
+		}
+		fields = append(fields, setField{fd, v})
+		return true
+	})
+	sort.Slice(fields, func(i, j int) bool {
+		if fields[i].fd.IsExtension() != fields[j].fd.IsExtension() {
+			return !fields[i].fd.IsExtension()
+		}
+		return fields[i].fd.Number() < fields[j].fd.Number()
+	})
+
+	var out []string
+	for _, sf := range fields {
+		name := string(sf.fd.Name())
+		if sf.fd.IsExtension() {
+			name = "(." + string(sf.fd.FullName()) + ")"
+		}
+		values := []protoreflect.Value{sf.v}
+		if sf.fd.IsList() {
+			values = values[:0]
+			for i := 0; i < sf.v.List().Len(); i++ {
+				values = append(values, sf.v.List().Get(i))
+			}
+		}
+		for _, v := range values {
+			lit, err := formatOptionValue(sf.fd, v)
+			if err != nil {
+				p.review("Option `%s` on %s could not be written as a literal (%v), so it was not carried over.", name, where, err)
+				continue
+			}
+			out = append(out, name+" = "+lit)
+		}
+	}
+	return out
+}
+
+// bracketOptions formats field style options, e.g. " [deprecated = true]".
+func bracketOptions(opts []string) string {
+	if len(opts) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(opts, ", ") + "]"
+}
+
+// formatRange formats an inclusive range of numbers as written in reserved and
+// extensions statements.
+func formatRange(start, end, max int32) string {
+	switch {
+	case start == end:
+		return strconv.Itoa(int(start))
+	case end == max:
+		return fmt.Sprintf("%d to max", start)
+	}
+	return fmt.Sprintf("%d to %d", start, end)
+}
+
+// formatOptionValue formats a single option value as a .proto literal.
+func formatOptionValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) (string, error) {
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name()), nil
+		}
+		return strconv.Itoa(int(v.Enum())), nil
+	case protoreflect.StringKind:
+		return protoQuote(v.String()), nil
+	case protoreflect.BytesKind:
+		return protoQuote(string(v.Bytes())), nil
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return formatFloat(v.Float()), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return formatMessageLiteral(v.Message())
+	}
+	return v.String(), nil
+}
+
+// formatMessageLiteral formats a message valued option as a text format literal,
+// e.g. "{ a: "x" b: 1 b: 2 }".  Unlike prototext, the output is stable across
+// builds.  Fields are ordered by number with extensions last, and map entries
+// by key.
+func formatMessageLiteral(m protoreflect.Message) (string, error) {
+	if len(m.GetUnknown()) > 0 {
+		return "", fmt.Errorf("%s holds unknown fields", m.Descriptor().FullName())
+	}
+	var fds []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fds = append(fds, fd)
+		return true
+	})
+	sort.Slice(fds, func(i, j int) bool {
+		if fds[i].IsExtension() != fds[j].IsExtension() {
+			return !fds[i].IsExtension()
+		}
+		return fds[i].Number() < fds[j].Number()
+	})
+
+	var parts []string
+	entry := func(fd protoreflect.FieldDescriptor, v protoreflect.Value) error {
+		lit, err := formatOptionValue(fd, v)
+		if err != nil {
+			return err
+		}
+		if fd.Message() != nil {
+			parts = append(parts, fd.TextName()+" "+lit)
+		} else {
+			parts = append(parts, fd.TextName()+": "+lit)
+		}
+		return nil
+	}
+	for _, fd := range fds {
+		v := m.Get(fd)
+		switch {
+		case fd.IsMap():
+			var keys []protoreflect.MapKey
+			v.Map().Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+				keys = append(keys, k)
+				return true
+			})
+			sort.Slice(keys, func(i, j int) bool { return mapKeyLess(keys[i], keys[j]) })
+			for _, k := range keys {
+				key, _ := formatOptionValue(fd.MapKey(), k.Value())
+				val, err := formatOptionValue(fd.MapValue(), v.Map().Get(k))
+				if err != nil {
+					return "", err
+				}
+				sep := ": "
+				if fd.MapValue().Message() != nil {
+					sep = " "
+				}
+				parts = append(parts, fmt.Sprintf("%s { key: %s value%s%s }", fd.TextName(), key, sep, val))
+			}
+		case fd.IsList():
+			for i := 0; i < v.List().Len(); i++ {
+				if err := entry(fd, v.List().Get(i)); err != nil {
+					return "", err
+				}
+			}
+		default:
+			if err := entry(fd, v); err != nil {
+				return "", err
+			}
+		}
+	}
+	if len(parts) == 0 {
+		return "{}", nil
+	}
+	return "{ " + strings.Join(parts, " ") + " }", nil
+}
+
+// mapKeyLess orders map keys, which are always bools, integers or strings.
+func mapKeyLess(a, b protoreflect.MapKey) bool {
+	switch av := a.Interface().(type) {
+	case bool:
+		return !av && b.Bool()
+	case int32, int64:
+		return a.Int() < b.Int()
+	case uint32, uint64:
+		return a.Uint() < b.Uint()
+	}
+	return a.String() < b.String()
+}
+
+// formatDefault formats the default value of a field.  The descriptor holds
+// defaults as text: bytes are already C-escaped, while strings are raw.
+func formatDefault(fd *descriptorpb.FieldDescriptorProto) string {
+	dv := fd.GetDefaultValue()
+	switch fd.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		return protoQuote(dv)
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		return `"` + dv + `"`
+	}
+	return dv
+}
+
+// formatFloat formats a floating point literal, using the identifiers .proto
+// files use for infinities and NaN.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// protoQuote returns a double quoted .proto string literal.  Bytes outside of
+// printable ASCII are octal escaped, so the literal is exact for any input.
+func protoQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, `\%03o`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// jsonCamelCase returns the JSON name protoc derives for a field name.
+func jsonCamelCase(s string) string {
+	var b []byte
+	upper := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '_':
+			upper = true
+		case upper && 'a' <= c && c <= 'z':
+			b = append(b, c-'a'+'A')
+			upper = false
+		default:
+			b = append(b, c)
+			upper = false
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,410 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+const editionsTestOptions = `
+syntax = "proto2";
+
+package test.options;
+
+import "google/protobuf/descriptor.proto";
+
+extend google.protobuf.FieldOptions {
+  optional string column = 50000;
+}
+
+extend google.protobuf.MessageOptions {
+  optional bool audited = 50001;
+}
+
+message Rule {
+  enum Level {
+    LEVEL_LOW = 0;
+    LEVEL_HIGH = 1;
+  }
+  optional string name = 1;
+  repeated int32 limits = 2;
+  optional Rule child = 3;
+  map<string, int32> weights = 4;
+  optional Level level = 5;
+}
+
+extend google.protobuf.FieldOptions {
+  optional Rule rule = 50002;
+}
+`
+
+func TestEditionsMigration(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		sources map[string]string
+		// contains lists text the migrated source must contain.
+		contains []string
+	}{
+		{
+			name: "proto2",
+			sources: map[string]string{
+				"test/options.proto": editionsTestOptions,
+				"test/migrate.proto": `
+// License header.
+
+syntax = "proto2";
+
+package test.proto2;
+
+import "test/options.proto";
+
+enum Color {
+  COLOR_RED = 0;
+  COLOR_BLUE = 1;
+}
+
+message Sample {
+  option (test.options.audited) = true;
+
+  required int64 id = 1;
+  optional string name = 2 [default = "anon\n\"quoted\""];
+  optional Color color = 3 [default = COLOR_BLUE];
+  optional double ratio = 4 [default = -inf];
+  repeated int32 packed_values = 5 [packed = true];
+  repeated int32 expanded_values = 6;
+  optional group Detail = 7 {
+    optional string note = 8;
+  }
+  map<string, int32> counts = 9;
+  oneof choice {
+    string text = 10 [(test.options.column) = "txt", (test.options.rule) = {
+      weights { key: "b" value: 2 }
+      weights { key: "a" value: 1 }
+      limits: 1
+      limits: 2
+      child { name: "inner" }
+      name: "outer"
+      level: LEVEL_HIGH
+    }];
+    Sample child = 11;
+  }
+  optional bytes blob = 12 [default = "\001\377"];
+  optional string renamed = 13 [json_name = "otherName"];
+
+  extensions 100 to max;
+  reserved 20 to 25, 30;
+  reserved "legacy", "old_name";
+}
+
+extend Sample {
+  repeated Color colors = 100;
+}
+`,
+			},
+			contains: []string{
+				`(.test.options.rule) = { name: "outer" limits: 1 limits: 2 child { name: "inner" } weights { key: "a" value: 1 } weights { key: "b" value: 2 } level: LEVEL_HIGH }`,
+			},
+		},
+		{
+			name: "proto3",
+			sources: map[string]string{
+				"test/options.proto": editionsTestOptions,
+				"test/migrate.proto": `
+syntax = "proto3";
+
+package test.proto3;
+
+import "test/options.proto";
+
+enum Status {
+  STATUS_UNSPECIFIED = 0;
+  STATUS_ACTIVE = 1;
+
+  reserved 5;
+  reserved "STATUS_GONE";
+}
+
+message Sample {
+  int64 id = 1 [(test.options.column) = "id"];
+  optional string name = 2;
+  optional Sample parent = 3;
+  repeated int32 packed_values = 4;
+  repeated int32 expanded_values = 5 [packed = false];
+  Status status = 6;
+  map<string, Sample> children = 7;
+  oneof choice {
+    string text = 8;
+    int32 number = 9;
+  }
+
+  message Nested {
+    optional Status status = 1;
+    repeated Status statuses = 2;
+  }
+  Nested nested = 10;
+
+  reserved 15;
+  reserved "legacy";
+}
+`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			const name = "test/migrate.proto"
+			req := editionsTestRequest(t, tc.sources, name)
+
+			out, err := generateEditionsMigration(req)
+			if err != nil {
+				t.Fatalf("generateEditionsMigration: %v", err)
+			}
+			var migrated string
+			for _, f := range out {
+				if f.GetName() == editionsOutputDir+"/"+name {
+					migrated = f.GetContent()
+				}
+			}
+			if migrated == "" {
+				t.Fatalf("no migrated file for %s", name)
+			}
+			for _, want := range tc.contains {
+				if !strings.Contains(migrated, want) {
+					t.Errorf("migrated source does not contain %s", want)
+				}
+			}
+
+			sources := map[string]string{}
+			for k, v := range tc.sources {
+				sources[k] = v
+			}
+			sources[name] = migrated
+			migratedReq := editionsTestRequest(t, sources, name)
+
+			want := editionsTestFile(t, req)
+			got := editionsTestFile(t, migratedReq)
+			if edition := protodesc.ToFileDescriptorProto(got).GetEdition(); edition != descriptorpb.Edition_EDITION_2023 {
+				t.Errorf("migrated file has edition %v, want EDITION_2023", edition)
+			}
+			compareEditionsTestFiles(t, want, got)
+			if t.Failed() {
+				t.Logf("migrated source:\n%s", migrated)
+			}
+		})
+	}
+}
+
+// editionsTestRequest compiles the named file from sources, returning a request
+// to generate it.
+func editionsTestRequest(t *testing.T, sources map[string]string, name string) *pluginpb.CodeGeneratorRequest {
+	t.Helper()
+	c := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(sources),
+		}),
+		SourceInfoMode: protocompile.SourceInfoStandard,
+	}
+	fds, err := c.Compile(context.Background(), name)
+	if err != nil {
+		t.Fatalf("compiling %s: %v", name, err)
+	}
+
+	req := &pluginpb.CodeGeneratorRequest{FileToGenerate: []string{name}}
+	seen := map[string]bool{}
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+		// list dependencies first, as protoc does.
+		for i := 0; i < fd.Imports().Len(); i++ {
+			add(fd.Imports().Get(i).FileDescriptor)
+		}
+		req.ProtoFile = append(req.ProtoFile, protodesc.ToFileDescriptorProto(fd))
+	}
+	for _, fd := range fds {
+		add(fd)
+	}
+	return req
+}
+
+// editionsTestFile builds the file to generate with protodesc, returning it
+// with all of its dependencies resolved.
+func editionsTestFile(t *testing.T, req *pluginpb.CodeGeneratorRequest) protoreflect.FileDescriptor {
+	t.Helper()
+	files := new(protoregistry.Files)
+	for _, fdp := range req.GetProtoFile() {
+		fd, err := protodesc.NewFile(fdp, files)
+		if err != nil {
+			t.Fatalf("protodesc.NewFile(%s): %v", fdp.GetName(), err)
+		}
+		if fdp.GetName() == req.GetFileToGenerate()[0] {
+			return fd
+		}
+		if err := files.RegisterFile(fd); err != nil {
+			t.Fatalf("registering %s: %v", fdp.GetName(), err)
+		}
+	}
+	t.Fatalf("%s not present in request", req.GetFileToGenerate()[0])
+	return nil
+}
+
+// editionsTestScope is implemented by files and messages, which can both hold
+// nested declarations.
+type editionsTestScope interface {
+	Messages() protoreflect.MessageDescriptors
+	Enums() protoreflect.EnumDescriptors
+	Extensions() protoreflect.ExtensionDescriptors
+}
+
+// compareEditionsTestFiles checks that every declaration in got has the same
+// semantics as the corresponding declaration in want.
+func compareEditionsTestFiles(t *testing.T, want, got protoreflect.FileDescriptor) {
+	t.Helper()
+	check := func(what, w, g string) {
+		if w != g {
+			t.Errorf("%s:\n got: %s\nwant: %s", what, g, w)
+		}
+	}
+
+	var walk func(w, g editionsTestScope)
+	walk = func(w, g editionsTestScope) {
+		for i := 0; i < w.Enums().Len(); i++ {
+			we := w.Enums().Get(i)
+			ge := g.Enums().ByName(we.Name())
+			if ge == nil {
+				t.Errorf("enum %s missing", we.FullName())
+				continue
+			}
+			check(string(we.FullName()), enumSignature(we), enumSignature(ge))
+		}
+		check("extension count", fmt.Sprint(w.Extensions().Len()), fmt.Sprint(g.Extensions().Len()))
+		for i := 0; i < w.Extensions().Len(); i++ {
+			wx := w.Extensions().Get(i)
+			gx := g.Extensions().ByName(wx.Name())
+			if gx == nil {
+				t.Errorf("extension %s missing", wx.FullName())
+				continue
+			}
+			check(string(wx.FullName()), fieldSignature(wx), fieldSignature(gx))
+		}
+		for i := 0; i < w.Messages().Len(); i++ {
+			wm := w.Messages().Get(i)
+			gm := g.Messages().ByName(wm.Name())
+			if gm == nil {
+				t.Errorf("message %s missing", wm.FullName())
+				continue
+			}
+			check(string(wm.FullName()), messageSignature(wm), messageSignature(gm))
+			for j := 0; j < wm.Fields().Len(); j++ {
+				wf := wm.Fields().Get(j)
+				gf := gm.Fields().ByNumber(wf.Number())
+				if gf == nil {
+					t.Errorf("field %s missing", wf.FullName())
+					continue
+				}
+				check(string(wf.FullName()), fieldSignature(wf), fieldSignature(gf))
+			}
+			walk(wm, gm)
+		}
+	}
+	walk(want, got)
+}
+
+// fieldSignature summarizes the semantics of a field which must survive migration.
+func fieldSignature(fd protoreflect.FieldDescriptor) string {
+	s := fmt.Sprintf("name=%s cardinality=%v kind=%v presence=%v packed=%v map=%v json=%s",
+		fd.Name(), fd.Cardinality(), fd.Kind(), fd.HasPresence(), fd.IsPacked(), fd.IsMap(), fd.JSONName())
+	if fd.HasDefault() {
+		s += fmt.Sprintf(" default=%#v", fd.Default().Interface())
+	}
+	if fd.Enum() != nil {
+		s += fmt.Sprintf(" enum=%s closed=%v", fd.Enum().FullName(), fd.Enum().IsClosed())
+	}
+	if fd.Message() != nil {
+		s += fmt.Sprintf(" message=%s", fd.Message().FullName())
+	}
+	if od := fd.ContainingOneof(); od != nil && !od.IsSynthetic() {
+		s += fmt.Sprintf(" oneof=%s", od.Name())
+	}
+	if fd.IsExtension() {
+		s += fmt.Sprintf(" extendee=%s", fd.ContainingMessage().FullName())
+	}
+	if opts := fd.Options().(*descriptorpb.FieldOptions); opts != nil {
+		// packed and features are expected to change, everything else must be carried over.
+		opts = proto.Clone(opts).(*descriptorpb.FieldOptions)
+		opts.Packed = nil
+		opts.Features = nil
+		s += optionsSignature(opts)
+	}
+	return s
+}
+
+// messageSignature summarizes the shape of a message, excluding its fields.
+func messageSignature(md protoreflect.MessageDescriptor) string {
+	var oneofs []protoreflect.Name
+	for i := 0; i < md.Oneofs().Len(); i++ {
+		if od := md.Oneofs().Get(i); !od.IsSynthetic() {
+			oneofs = append(oneofs, od.Name())
+		}
+	}
+	return fmt.Sprintf("fields=%d oneofs=%v reserved_names=%s reserved_ranges=%v extension_ranges=%v",
+		md.Fields().Len(), oneofs, reservedNames(md.ReservedNames()), md.ReservedRanges(), md.ExtensionRanges()) +
+		optionsSignature(md.Options())
+}
+
+// enumSignature summarizes the semantics of an enum.
+func enumSignature(ed protoreflect.EnumDescriptor) string {
+	s := fmt.Sprintf("closed=%v reserved_names=%s reserved_ranges=%v", ed.IsClosed(), reservedNames(ed.ReservedNames()), ed.ReservedRanges())
+	for i := 0; i < ed.Values().Len(); i++ {
+		v := ed.Values().Get(i)
+		s += fmt.Sprintf(" %s=%d", v.Name(), v.Number())
+	}
+	return s
+}
+
+func reservedNames(names protoreflect.Names) string {
+	var out []protoreflect.Name
+	for i := 0; i < names.Len(); i++ {
+		out = append(out, names.Get(i))
+	}
+	return fmt.Sprint(out)
+}
+
+// optionsSignature renders options deterministically, including custom options.
+func optionsSignature(opts proto.Message) string {
+	if opts == nil || !opts.ProtoReflect().IsValid() {
+		return ""
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(opts)
+	if err != nil {
+		return fmt.Sprintf(" options=<%v>", err)
+	}
+	if len(b) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" options=%x", b)
+}
@@ -0,0 +1,225 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// extensionRegistryExtendee lists the extensions of a single message, as written
+// to extension_registry.json.
+type extensionRegistryExtendee struct {
+	Name       string                   `json:"name"`
+	Extensions []extensionRegistryEntry `json:"extensions"`
+	Conflicts  []string                 `json:"conflicts,omitempty"`
+}
+
+// extensionRegistryEntry describes a single extension.
+type extensionRegistryEntry struct {
+	Number   int32  `json:"number"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Repeated bool   `json:"repeated,omitempty"`
+	File     string `json:"file"`
+}
+
+// extensionInfo describes an extension declaration found while walking a request.
+type extensionInfo struct {
+	// name is the fully qualified name of the extension, without a leading dot.
+	name string
+	file string
+	desc *descriptorpb.FieldDescriptorProto
+}
+
+// generateExtensionReport produces a registry of every extension in the request,
+// grouped by the message it extends, as both Markdown and JSON documents.  It
+// flags numbers used more than once on the same extendee, and numbers outside of
+// the extendee's declared extension ranges.
+//
+// protoc already rejects both problems within a single request, so the checks
+// mostly matter for requests assembled by other tools.  Collisions between files
+// built separately, such as custom options from different teams, are found by
+// merging or diffing the JSON registries of each build.
+func generateExtensionReport(req *pluginpb.CodeGeneratorRequest) ([]*pluginpb.CodeGeneratorResponse_File, error) {
+	idx := newDescriptorIndex(req)
+
+	byExtendee := map[string][]*extensionInfo{}
+	for _, f := range req.GetProtoFile() {
+		prefix := packagePrefix(f)
+		collectExtensions(f.GetExtension(), f.GetName(), prefix, byExtendee)
+		for _, m := range f.GetMessageType() {
+			for _, mi := range idx.localMessages(m, prefix, prefix) {
+				collectExtensions(mi.desc.GetExtension(), f.GetName(), mi.fqn, byExtendee)
+			}
+		}
+	}
+
+	var extendees []string
+	numExtensions := 0
+	for extendee, exts := range byExtendee {
+		extendees = append(extendees, extendee)
+		numExtensions = numExtensions + len(exts)
+		sort.SliceStable(exts, func(i, j int) bool {
+			return exts[i].desc.GetNumber() < exts[j].desc.GetNumber()
+		})
+	}
+	sort.Strings(extendees)
+
+	body := new(bytes.Buffer)
+	numConflicts := 0
+	registry := []extensionRegistryExtendee{}
+	for _, extendee := range extendees {
+		exts := byExtendee[extendee]
+		entry := extensionRegistryExtendee{
+			Name:       strings.TrimPrefix(extendee, "."),
+			Extensions: []extensionRegistryEntry{},
+		}
+		fmt.Fprintf(body, "\n## %s\n\n", entry.Name)
+		fmt.Fprintln(body, "| number | extension | type | declared in |")
+		fmt.Fprintln(body, "|---|---|---|---|")
+		for _, x := range exts {
+			repeated := x.desc.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+			typ := fieldTypeName(x.desc)
+			entry.Extensions = append(entry.Extensions, extensionRegistryEntry{
+				Number:   x.desc.GetNumber(),
+				Name:     x.name,
+				Type:     typ,
+				Repeated: repeated,
+				File:     x.file,
+			})
+			if repeated {
+				typ = "repeated " + typ
+			}
+			fmt.Fprintf(body, "| %d | %s | %s | %s |\n", x.desc.GetNumber(), x.name, typ, x.file)
+		}
+
+		conflicts := extensionConflicts(idx, extendee, exts)
+		numConflicts = numConflicts + len(conflicts)
+		entry.Conflicts = conflicts
+		registry = append(registry, entry)
+		if len(conflicts) > 0 {
+			fmt.Fprintln(body)
+			fmt.Fprintln(body, "### conflicts")
+			fmt.Fprintln(body)
+			for _, c := range conflicts {
+				fmt.Fprintf(body, "- %s\n", c)
+			}
+		}
+	}
+
+	jsonBytes, err := json.MarshalIndent(struct {
+		Extendees []extensionRegistryExtendee `json:"extendees"`
+	}{registry}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("json.MarshalIndent: %w", err)
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "# extension registry")
+	fmt.Fprintln(buf)
+	fmt.Fprintf(buf, "%d extensions of %d extendees.", numExtensions, len(extendees))
+	if numConflicts > 0 {
+		fmt.Fprintf(buf, " %d conflicts were found within this request.", numConflicts)
+	}
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "protoc rejects conflicting extensions within a single compilation, so this report cannot detect")
+	fmt.Fprintln(buf, "collisions with files built separately.  Merge or diff `extension_registry.json` across builds to find them.")
+	buf.Write(body.Bytes())
+
+	return []*pluginpb.CodeGeneratorResponse_File{
+		{
+			Name:    proto.String("extension_registry.md"),
+			Content: proto.String(buf.String()),
+		},
+		{
+			Name:    proto.String("extension_registry.json"),
+			Content: proto.String(string(jsonBytes) + "\n"),
+		},
+	}, nil
+}
+
+// collectExtensions records extensions declared within the given scope, keyed by
+// the fully qualified name of their extendee.
+func collectExtensions(exts []*descriptorpb.FieldDescriptorProto, file, scope string, out map[string][]*extensionInfo) {
+	for _, x := range exts {
+		out[x.GetExtendee()] = append(out[x.GetExtendee()], &extensionInfo{
+			name: strings.TrimPrefix(scope+"."+x.GetName(), "."),
+			file: file,
+			desc: x,
+		})
+	}
+}
+
+// extensionConflicts describes the problems with the extensions of a single
+// extendee.  The extensions must be sorted by number.
+func extensionConflicts(idx *descriptorIndex, extendee string, exts []*extensionInfo) []string {
+	var conflicts []string
+	for i := 0; i < len(exts); {
+		j := i + 1
+		for j < len(exts) && exts[j].desc.GetNumber() == exts[i].desc.GetNumber() {
+			j++
+		}
+		if j-i > 1 {
+			var users []string
+			for _, x := range exts[i:j] {
+				users = append(users, fmt.Sprintf("`%s` (%s)", x.name, x.file))
+			}
+			conflicts = append(conflicts, fmt.Sprintf("number %d is used by %s.", exts[i].desc.GetNumber(), strings.Join(users, ", ")))
+		}
+		i = j
+	}
+
+	mi, ok := idx.messages[extendee]
+	if !ok {
+		// the extendee is always present in a request from protoc, but be defensive.
+		return conflicts
+	}
+	var ranges []string
+	for _, er := range mi.desc.GetExtensionRange() {
+		ranges = append(ranges, formatRange(er.GetStart(), er.GetEnd()-1, maxFieldNumber))
+	}
+	for _, x := range exts {
+		if inExtensionRange(mi.desc, x.desc.GetNumber()) {
+			continue
+		}
+		declared := "none"
+		if len(ranges) > 0 {
+			declared = strings.Join(ranges, ", ")
+		}
+		conflicts = append(conflicts, fmt.Sprintf("`%s` (%s) uses number %d, outside of the declared extension ranges (%s).",
+			x.name, x.file, x.desc.GetNumber(), declared))
+	}
+	return conflicts
+}
+
+// inExtensionRange reports whether num falls within one of the message's
+// extension ranges.  Range ends are exclusive.
+func inExtensionRange(dp *descriptorpb.DescriptorProto, num int32) bool {
+	for _, er := range dp.GetExtensionRange() {
+		if num >= er.GetStart() && num < er.GetEnd() {
+			return true
+		}
+	}
+	return false
+}
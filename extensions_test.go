@@ -0,0 +1,118 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// extensionTestField returns an optional int32 extension of a.Base.
+func extensionTestField(name string, number int32) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		Number:   proto.Int32(number),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+		Extendee: proto.String(".a.Base"),
+	}
+}
+
+func TestExtensionReport(t *testing.T) {
+	// protoc rejects both conflicts, so the request is built by hand.
+	req := &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"b.proto", "c.proto"},
+		ProtoFile: []*descriptorpb.FileDescriptorProto{
+			{
+				Name:    proto.String("a.proto"),
+				Package: proto.String("a"),
+				MessageType: []*descriptorpb.DescriptorProto{{
+					Name: proto.String("Base"),
+					ExtensionRange: []*descriptorpb.DescriptorProto_ExtensionRange{
+						{Start: proto.Int32(100), End: proto.Int32(200)},
+					},
+				}},
+			},
+			{
+				Name:       proto.String("b.proto"),
+				Package:    proto.String("b"),
+				Dependency: []string{"a.proto"},
+				Extension:  []*descriptorpb.FieldDescriptorProto{extensionTestField("first", 150)},
+			},
+			{
+				Name:       proto.String("c.proto"),
+				Package:    proto.String("c"),
+				Dependency: []string{"a.proto"},
+				MessageType: []*descriptorpb.DescriptorProto{{
+					Name:      proto.String("Holder"),
+					Extension: []*descriptorpb.FieldDescriptorProto{extensionTestField("second", 150)},
+				}},
+				Extension: []*descriptorpb.FieldDescriptorProto{extensionTestField("outside", 300)},
+			},
+		},
+	}
+
+	files, err := generateExtensionReport(req)
+	if err != nil {
+		t.Fatalf("generateExtensionReport: %v", err)
+	}
+	contents := map[string]string{}
+	for _, f := range files {
+		contents[f.GetName()] = f.GetContent()
+	}
+
+	var registry struct {
+		Extendees []extensionRegistryExtendee `json:"extendees"`
+	}
+	if err := json.Unmarshal([]byte(contents["extension_registry.json"]), &registry); err != nil {
+		t.Fatalf("extension_registry.json: %v", err)
+	}
+	if len(registry.Extendees) != 1 || registry.Extendees[0].Name != "a.Base" {
+		t.Fatalf("extendees = %+v, want only a.Base", registry.Extendees)
+	}
+	base := registry.Extendees[0]
+
+	var names []string
+	for _, x := range base.Extensions {
+		names = append(names, x.Name)
+	}
+	if got, want := strings.Join(names, ","), "b.first,c.Holder.second,c.outside"; got != want {
+		t.Errorf("extensions = %s, want %s", got, want)
+	}
+
+	for _, want := range []string{
+		"number 150 is used by `b.first` (b.proto), `c.Holder.second` (c.proto).",
+		"`c.outside` (c.proto) uses number 300, outside of the declared extension ranges (100 to 199).",
+	} {
+		found := false
+		for _, c := range base.Conflicts {
+			found = found || c == want
+		}
+		if !found {
+			t.Errorf("conflicts = %q, missing %q", base.Conflicts, want)
+		}
+		if !strings.Contains(contents["extension_registry.md"], want) {
+			t.Errorf("extension_registry.md is missing %q", want)
+		}
+	}
+	if len(base.Conflicts) != 2 {
+		t.Errorf("got %d conflicts, want 2: %q", len(base.Conflicts), base.Conflicts)
+	}
+}
@@ -2,4 +2,9 @@ module protoc-gen-pluginexample
 
 go 1.23
 
-require google.golang.org/protobuf v1.34.2
+require (
+	github.com/bufbuild/protocompile v0.14.1
+	google.golang.org/protobuf v1.34.2
+)
+
+require golang.org/x/sync v0.8.0 // indirect
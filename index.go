@@ -0,0 +1,184 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// messageInfo describes a message definition found while indexing a request.
+type messageInfo struct {
+	// fqn is the fully qualified name with a leading dot, as used by type_name references.
+	fqn string
+	// goName is the name of the generated Go type, e.g. AddressInfo_ZipCode.
+	goName string
+	desc   *descriptorpb.DescriptorProto
+	file   *descriptorpb.FileDescriptorProto
+}
+
+// descriptorIndex allows resolving type_name references against every file
+// present in a request, not just the files to generate.
+type descriptorIndex struct {
+	files    map[string]*descriptorpb.FileDescriptorProto
+	messages map[string]*messageInfo
+	enums    map[string]*descriptorpb.EnumDescriptorProto
+	// enumFiles records the file declaring each enum.
+	enumFiles map[string]*descriptorpb.FileDescriptorProto
+}
+
+// newDescriptorIndex walks all files in the request, recording messages and enums
+// by their fully qualified names.
+func newDescriptorIndex(req *pluginpb.CodeGeneratorRequest) *descriptorIndex {
+	idx := &descriptorIndex{
+		files:     make(map[string]*descriptorpb.FileDescriptorProto),
+		messages:  make(map[string]*messageInfo),
+		enums:     make(map[string]*descriptorpb.EnumDescriptorProto),
+		enumFiles: make(map[string]*descriptorpb.FileDescriptorProto),
+	}
+	for _, f := range req.GetProtoFile() {
+		idx.files[f.GetName()] = f
+		prefix := packagePrefix(f)
+		for _, e := range f.GetEnumType() {
+			idx.enums[prefix+"."+e.GetName()] = e
+			idx.enumFiles[prefix+"."+e.GetName()] = f
+		}
+		for _, m := range f.GetMessageType() {
+			for _, mi := range idx.localMessages(m, prefix, prefix) {
+				mi.file = f
+				idx.messages[mi.fqn] = mi
+				for _, e := range mi.desc.GetEnumType() {
+					idx.enumFiles[mi.fqn+"."+e.GetName()] = f
+				}
+			}
+		}
+	}
+	return idx
+}
+
+// localMessages returns the message and all of its nested messages, in
+// declaration order.  Names are qualified by scope, and Go names are derived
+// from the name relative to pkgPrefix, as protoc-gen-go does.  Nested enums are
+// recorded in the index as a side effect.
+func (idx *descriptorIndex) localMessages(dp *descriptorpb.DescriptorProto, scope, pkgPrefix string) []*messageInfo {
+	fqn := fmt.Sprintf("%s.%s", scope, dp.GetName())
+	goName := goCamelCase(strings.TrimPrefix(fqn, pkgPrefix+"."))
+	out := []*messageInfo{{fqn: fqn, goName: goName, desc: dp}}
+	for _, e := range dp.GetEnumType() {
+		idx.enums[fqn+"."+e.GetName()] = e
+	}
+	for _, child := range dp.GetNestedType() {
+		out = append(out, idx.localMessages(child, fqn, pkgPrefix)...)
+	}
+	return out
+}
+
+// packagePrefix returns the prefix used to fully qualify names declared in the file.
+func packagePrefix(f *descriptorpb.FileDescriptorProto) string {
+	if f.GetPackage() == "" {
+		return ""
+	}
+	return "." + f.GetPackage()
+}
+
+// mapEntry reports whether the field is a map, returning the synthetic entry
+// message that holds its key and value fields.
+func (idx *descriptorIndex) mapEntry(fd *descriptorpb.FieldDescriptorProto) (*descriptorpb.DescriptorProto, bool) {
+	if fd.GetLabel() != descriptorpb.FieldDescriptorProto_LABEL_REPEATED || !isMessageField(fd) {
+		return nil, false
+	}
+	mi, ok := idx.messages[fd.GetTypeName()]
+	if !ok || !mi.desc.GetOptions().GetMapEntry() || len(mi.desc.GetField()) != 2 {
+		return nil, false
+	}
+	return mi.desc, true
+}
+
+// isMessageField reports whether the field holds a message or group.
+func isMessageField(fd *descriptorpb.FieldDescriptorProto) bool {
+	return fd.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE ||
+		fd.GetType() == descriptorpb.FieldDescriptorProto_TYPE_GROUP
+}
+
+// goPackage returns the go import path and package name for a file, following
+// the same go_package conventions as protoc-gen-go.  Files without a go_package
+// fall back to their directory and proto package.
+func goPackage(f *descriptorpb.FileDescriptorProto) (string, string) {
+	opt := f.GetOptions().GetGoPackage()
+	if opt == "" {
+		name := strings.ReplaceAll(f.GetPackage(), ".", "_")
+		if name == "" {
+			name = strings.TrimSuffix(path.Base(f.GetName()), ".proto")
+		}
+		return path.Dir(f.GetName()), goSanitize(name)
+	}
+	importPath, name, found := strings.Cut(opt, ";")
+	importPath = path.Clean(importPath)
+	if !found {
+		name = path.Base(importPath)
+	}
+	return importPath, goSanitize(name)
+}
+
+// goSanitize converts a string into a valid Go identifier.
+func goSanitize(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, s)
+	if s == "" || ('0' <= s[0] && s[0] <= '9') {
+		s = "_" + s
+	}
+	return s
+}
+
+// goCamelCase converts a protobuf name into the CamelCase form used for
+// generated Go identifiers.  It mirrors the conversion used by protoc-gen-go.
+func goCamelCase(s string) string {
+	isLower := func(c byte) bool { return 'a' <= c && c <= 'z' }
+	isDigit := func(c byte) bool { return '0' <= c && c <= '9' }
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '.' && i+1 < len(s) && isLower(s[i+1]):
+			// skip over '.' in ".{{lowercase}}".
+		case c == '.':
+			b = append(b, '_')
+		case c == '_' && (i == 0 || s[i-1] == '.'):
+			// convert an initial '_' to ensure we start with a capital letter.
+			b = append(b, 'X')
+		case c == '_' && i+1 < len(s) && isLower(s[i+1]):
+			// skip over '_' in "_{{lowercase}}".
+		case isDigit(c):
+			b = append(b, c)
+		default:
+			if isLower(c) {
+				c -= 'a' - 'A'
+			}
+			b = append(b, c)
+			for ; i+1 < len(s) && isLower(s[i+1]); i++ {
+				b = append(b, s[i+1])
+			}
+		}
+	}
+	return string(b)
+}
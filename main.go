@@ -21,6 +21,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"strings"
 
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
@@ -86,10 +87,71 @@ func processRequest(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGenerator
 	}
 	resp.File = append(resp.File, f)
 
+	// now, produce a reference of the wire tags for each field.
+	wtFiles, err := generateWireTags(req)
+	if err != nil {
+		return nil, fmt.Errorf("generateWireTags failed: %w", err)
+	}
+	resp.File = append(resp.File, wtFiles...)
+
+	// now, produce edition 2023 equivalents of any proto2 and proto3 files, if requested.
+	if hasParameter(req, "editions") {
+		edFiles, err := generateEditionsMigration(req)
+		if err != nil {
+			return nil, fmt.Errorf("generateEditionsMigration failed: %w", err)
+		}
+		resp.File = append(resp.File, edFiles...)
+	}
+
+	// now, report on extensions and any conflicts between them.
+	exFiles, err := generateExtensionReport(req)
+	if err != nil {
+		return nil, fmt.Errorf("generateExtensionReport failed: %w", err)
+	}
+	resp.File = append(resp.File, exFiles...)
+
+	// now, export a C4 model of the request as a Structurizr DSL fragment.
+	f, err = generateC4Model(req)
+	if err != nil {
+		return nil, fmt.Errorf("generateC4Model failed: %w", err)
+	}
+	resp.File = append(resp.File, f)
+
+	// finally, produce round-trip conformance tests to accompany the protoc-gen-go output, if requested.
+	if hasParameter(req, "roundtrip") {
+		rtFiles, err := generateRoundTripTests(req)
+		if err != nil {
+			return nil, fmt.Errorf("generateRoundTripTests failed: %w", err)
+		}
+		resp.File = append(resp.File, rtFiles...)
+	}
+
 	// return the response
 	return resp, nil
 }
 
+// hasParameter reports whether name appears in the comma separated parameter
+// passed to the plugin, e.g. via --pluginexample_opt=editions.
+func hasParameter(req *pluginpb.CodeGeneratorRequest, name string) bool {
+	for _, p := range strings.Split(req.GetParameter(), ",") {
+		if strings.TrimSpace(p) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parameterValue returns the value of a name=value pair in the comma separated
+// parameter passed to the plugin, e.g. via --pluginexample_opt=paths=source_relative.
+func parameterValue(req *pluginpb.CodeGeneratorRequest, name string) (string, bool) {
+	for _, p := range strings.Split(req.GetParameter(), ",") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && k == name {
+			return v, true
+		}
+	}
+	return "", false
+}
+
 // recordRequest constructs a File entity the contains the JSON-formatted contents
 // of the incoming request.
 func recordRequest(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse_File, error) {
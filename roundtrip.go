@@ -0,0 +1,376 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// roundtripDepth bounds how deeply the generated tests populate nested messages,
+// which keeps recursive message definitions finite.
+const roundtripDepth = 3
+
+// generateRoundTripTests produces a Go test file for each file to generate.  The
+// tests live alongside the protoc-gen-go output for the same file, and check that
+// populated messages survive binary, protojson and textproto round-trips.
+//
+// The paths and module parameters are interpreted as protoc-gen-go does, and
+// should match those passed to it.
+func generateRoundTripTests(req *pluginpb.CodeGeneratorRequest) ([]*pluginpb.CodeGeneratorResponse_File, error) {
+	paths, _ := parameterValue(req, "paths")
+	if paths != "" && paths != "import" && paths != "source_relative" {
+		return nil, fmt.Errorf(`unknown path type %q: want "import" or "source_relative"`, paths)
+	}
+	module, _ := parameterValue(req, "module")
+	if module != "" && paths == "source_relative" {
+		return nil, fmt.Errorf("cannot use module= with paths=source_relative")
+	}
+
+	idx := newDescriptorIndex(req)
+	var out []*pluginpb.CodeGeneratorResponse_File
+	for _, name := range req.GetFileToGenerate() {
+		f, ok := idx.files[name]
+		if !ok {
+			return nil, fmt.Errorf("file to generate %q not present in request", name)
+		}
+		if len(f.GetMessageType()) == 0 {
+			continue
+		}
+		importPath, pkgName := goPackage(f)
+		// name the file the way protoc-gen-go names its output for the same proto file.
+		prefix := strings.TrimSuffix(f.GetName(), ".proto")
+		if paths != "source_relative" {
+			prefix = path.Join(importPath, path.Base(prefix))
+		}
+		if module != "" {
+			if !strings.HasPrefix(prefix, module+"/") {
+				return nil, fmt.Errorf("%s: generated file does not match prefix %q", prefix+"_roundtrip_test.go", module)
+			}
+			prefix = strings.TrimPrefix(prefix, module+"/")
+		}
+
+		buf := new(bytes.Buffer)
+		writeRoundTripTest(buf, idx, f, pkgName)
+		out = append(out, &pluginpb.CodeGeneratorResponse_File{
+			Name:    proto.String(prefix + "_roundtrip_test.go"),
+			Content: proto.String(buf.String()),
+		})
+	}
+	return out, nil
+}
+
+// writeRoundTripTest emits the contents of a single round-trip test file.
+func writeRoundTripTest(w io.Writer, idx *descriptorIndex, f *descriptorpb.FileDescriptorProto, pkgName string) {
+	// helpers are prefixed per proto file, so several test files can share a go package.
+	prefix := "rt" + goCamelCase(strings.NewReplacer("/", ".", "-", "_").Replace(strings.TrimSuffix(f.GetName(), ".proto")))
+	prefix = strings.ReplaceAll(prefix, "_", "")
+
+	fmt.Fprintln(w, "// Code generated by protoc-gen-pluginexample. DO NOT EDIT.")
+	fmt.Fprintf(w, "// source: %s\n\n", f.GetName())
+	fmt.Fprintf(w, "package %s\n\n", pkgName)
+	fmt.Fprintln(w, "import (")
+	fmt.Fprintln(w, "\t\"testing\"")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "\t\"google.golang.org/protobuf/encoding/protojson\"")
+	fmt.Fprintln(w, "\t\"google.golang.org/protobuf/encoding/prototext\"")
+	fmt.Fprintln(w, "\t\"google.golang.org/protobuf/proto\"")
+	fmt.Fprintln(w, "\t\"google.golang.org/protobuf/reflect/protoreflect\"")
+	fmt.Fprintln(w, ")")
+
+	// collect the messages declared in this file, along with everything they reach.
+	var local []*messageInfo
+	for _, m := range f.GetMessageType() {
+		local = append(local, idx.localMessages(m, packagePrefix(f), packagePrefix(f))...)
+	}
+	reachable := map[string]bool{}
+	var order []string
+	var visit func(fqn string)
+	visit = func(fqn string) {
+		if reachable[fqn] {
+			return
+		}
+		reachable[fqn] = true
+		order = append(order, fqn)
+		mi, ok := idx.messages[fqn]
+		if !ok {
+			return
+		}
+		for _, fd := range mi.desc.GetField() {
+			if entry, ok := idx.mapEntry(fd); ok {
+				// map entries are populated inline, only their values need a function.
+				fd = entry.GetField()[1]
+			}
+			if isMessageField(fd) {
+				visit(fd.GetTypeName())
+			}
+		}
+	}
+	for _, mi := range local {
+		if !mi.desc.GetOptions().GetMapEntry() {
+			visit(mi.fqn)
+		}
+	}
+
+	fmt.Fprintf(w, "\nfunc Test%sRoundTrip(t *testing.T) {\n", strings.TrimPrefix(prefix, "rt"))
+	fmt.Fprintln(w, "\tfor _, tc := range []struct {")
+	fmt.Fprintln(w, "\t\tname string")
+	fmt.Fprintln(w, "\t\tmsg  proto.Message")
+	fmt.Fprintln(w, "\t}{")
+	for _, mi := range local {
+		if mi.desc.GetOptions().GetMapEntry() {
+			continue
+		}
+		fmt.Fprintf(w, "\t\t{%q, &%s{}},\n", mi.goName+"/empty", mi.goName)
+		fmt.Fprintf(w, "\t\t{%q, %sPopulate(%s, &%s{})},\n", mi.goName+"/populated", prefix, populateFuncName(prefix, mi.fqn), mi.goName)
+		fmt.Fprintf(w, "\t\t{%q, %sExplicitDefaults(&%s{})},\n", mi.goName+"/explicit_defaults", prefix, mi.goName)
+	}
+	fmt.Fprintln(w, "\t} {")
+	fmt.Fprintln(w, "\t\tt.Run(tc.name, func(t *testing.T) {")
+	fmt.Fprintf(w, "\t\t\t%sCheck(t, tc.msg)\n", prefix)
+	fmt.Fprintln(w, "\t\t})")
+	fmt.Fprintln(w, "\t}")
+	fmt.Fprintln(w, "}")
+
+	fmt.Fprintf(w, roundtripHelpers, prefix, roundtripDepth)
+
+	for _, fqn := range order {
+		mi, ok := idx.messages[fqn]
+		if !ok {
+			continue
+		}
+		writePopulateFunc(w, idx, prefix, mi)
+	}
+}
+
+// roundtripHelpers holds the message independent portion of each round-trip test
+// file.  It is formatted with the per-file helper prefix.
+const roundtripHelpers = `
+// %[1]sCheck asserts that msg survives binary, protojson and textproto round-trips.
+func %[1]sCheck(t *testing.T, want proto.Message) {
+	t.Helper()
+
+	b, err := proto.MarshalOptions{AllowPartial: true, Deterministic: true}.Marshal(want)
+	if err != nil {
+		t.Fatalf("proto.Marshal: %%v", err)
+	}
+	got := want.ProtoReflect().New().Interface()
+	if err := (proto.UnmarshalOptions{AllowPartial: true}).Unmarshal(b, got); err != nil {
+		t.Fatalf("proto.Unmarshal: %%v", err)
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("binary round-trip mismatch\ngot:  %%v\nwant: %%v", got, want)
+	}
+
+	j, err := protojson.MarshalOptions{AllowPartial: true}.Marshal(want)
+	if err != nil {
+		t.Fatalf("protojson.Marshal: %%v", err)
+	}
+	got = want.ProtoReflect().New().Interface()
+	if err := (protojson.UnmarshalOptions{AllowPartial: true}).Unmarshal(j, got); err != nil {
+		t.Fatalf("protojson.Unmarshal(%%s): %%v", j, err)
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("protojson round-trip mismatch via %%s\ngot:  %%v\nwant: %%v", j, got, want)
+	}
+
+	txt, err := prototext.MarshalOptions{AllowPartial: true}.Marshal(want)
+	if err != nil {
+		t.Fatalf("prototext.Marshal: %%v", err)
+	}
+	got = want.ProtoReflect().New().Interface()
+	if err := (prototext.UnmarshalOptions{AllowPartial: true}).Unmarshal(txt, got); err != nil {
+		t.Fatalf("prototext.Unmarshal(%%s): %%v", txt, err)
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("prototext round-trip mismatch via %%s\ngot:  %%v\nwant: %%v", txt, got, want)
+	}
+}
+
+// %[1]sPopulate fills msg with sample values using fn.
+func %[1]sPopulate(fn func(protoreflect.Message, int), msg proto.Message) proto.Message {
+	fn(msg.ProtoReflect(), %[2]d)
+	return msg
+}
+
+// %[1]sExplicitDefaults explicitly sets every field with presence to its default
+// value, so the round-trip must preserve presence rather than the value alone.
+func %[1]sExplicitDefaults(msg proto.Message) proto.Message {
+	m := msg.ProtoReflect()
+	seen := map[protoreflect.FullName]bool{}
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.IsList() || fd.IsMap() || !fd.HasPresence() {
+			continue
+		}
+		if od := fd.ContainingOneof(); od != nil {
+			// only a single member of a oneof may be set.
+			if seen[od.FullName()] {
+				continue
+			}
+			seen[od.FullName()] = true
+		}
+		if fd.Message() != nil {
+			v := m.Mutable(fd).Message()
+			if fd.Message().FullName() == "google.protobuf.Value" {
+				// an empty Value has no JSON form, so give it the null kind.
+				kind := fd.Message().Fields().ByName("null_value")
+				v.Set(kind, kind.Default())
+			}
+			continue
+		}
+		m.Set(fd, fd.Default())
+	}
+	return msg
+}
+`
+
+// writePopulateFunc emits a function which sets every field of the message to a
+// sample value.  Only the first member of each oneof is populated.
+func writePopulateFunc(w io.Writer, idx *descriptorIndex, prefix string, mi *messageInfo) {
+	fmt.Fprintf(w, "\n// %s populates %s with sample values.\n", populateFuncName(prefix, mi.fqn), strings.TrimPrefix(mi.fqn, "."))
+	fmt.Fprintf(w, "func %s(m protoreflect.Message, depth int) {\n", populateFuncName(prefix, mi.fqn))
+
+	switch mi.fqn {
+	case ".google.protobuf.Any":
+		// an Any is only serializable as JSON when the contained type is resolvable.
+		fmt.Fprintln(w, "\t// Any is left empty, as protojson requires a resolvable type_url.")
+		fmt.Fprintln(w, "}")
+		return
+	case ".google.protobuf.Timestamp", ".google.protobuf.Duration":
+		// the JSON forms only accept a bounded range of seconds and nanos.
+		fmt.Fprintln(w, "\tfields := m.Descriptor().Fields()")
+		fmt.Fprintln(w, "\tm.Set(fields.ByNumber(1), protoreflect.ValueOfInt64(1700000000))")
+		fmt.Fprintln(w, "\tm.Set(fields.ByNumber(2), protoreflect.ValueOfInt32(500000000))")
+		fmt.Fprintln(w, "}")
+		return
+	}
+
+	if len(mi.desc.GetField()) == 0 {
+		fmt.Fprintln(w, "}")
+		return
+	}
+	fmt.Fprintln(w, "\tfields := m.Descriptor().Fields()")
+	seenOneof := map[int32]bool{}
+	for _, fd := range mi.desc.GetField() {
+		if fd.OneofIndex != nil && !fd.GetProto3Optional() {
+			if seenOneof[fd.GetOneofIndex()] {
+				continue
+			}
+			seenOneof[fd.GetOneofIndex()] = true
+		}
+		fdExpr := fmt.Sprintf("fields.ByNumber(%d)", fd.GetNumber())
+
+		if entry, ok := idx.mapEntry(fd); ok {
+			key, val := entry.GetField()[0], entry.GetField()[1]
+			keyExpr := fmt.Sprintf("%s.MapKey()", idx.sampleValue(key))
+			fmt.Fprintf(w, "\t{\n\t\tmp := m.Mutable(%s).Map()\n", fdExpr)
+			if isMessageField(val) {
+				fmt.Fprintln(w, "\t\tif depth > 0 {")
+				fmt.Fprintf(w, "\t\t\t%s(mp.Mutable(%s).Message(), depth-1)\n", populateFuncName(prefix, val.GetTypeName()), keyExpr)
+				fmt.Fprintln(w, "\t\t}")
+			} else {
+				fmt.Fprintf(w, "\t\tmp.Set(%s, %s)\n", keyExpr, idx.sampleValue(val))
+			}
+			fmt.Fprintln(w, "\t}")
+			continue
+		}
+
+		switch {
+		case fd.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED && isMessageField(fd):
+			fmt.Fprintln(w, "\tif depth > 0 {")
+			fmt.Fprintf(w, "\t\t%s(m.Mutable(%s).List().AppendMutable().Message(), depth-1)\n", populateFuncName(prefix, fd.GetTypeName()), fdExpr)
+			fmt.Fprintln(w, "\t}")
+		case fd.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED:
+			// two elements, so packed and unpacked encodings both carry more than one value.
+			fmt.Fprintf(w, "\t{\n\t\tl := m.Mutable(%s).List()\n", fdExpr)
+			fmt.Fprintf(w, "\t\tl.Append(%s)\n", idx.sampleValue(fd))
+			fmt.Fprintf(w, "\t\tl.Append(%s)\n", idx.sampleValue(fd))
+			fmt.Fprintln(w, "\t}")
+		case isMessageField(fd):
+			fmt.Fprintln(w, "\tif depth > 0 {")
+			fmt.Fprintf(w, "\t\t%s(m.Mutable(%s).Message(), depth-1)\n", populateFuncName(prefix, fd.GetTypeName()), fdExpr)
+			fmt.Fprintln(w, "\t}")
+		default:
+			fmt.Fprintf(w, "\tm.Set(%s, %s)\n", fdExpr, idx.sampleValue(fd))
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// populateFuncName returns the name of the generated populate function for the
+// fully qualified message name.
+func populateFuncName(prefix, fqn string) string {
+	return prefix + "Populate" + goCamelCase(strings.TrimPrefix(fqn, "."))
+}
+
+// sampleValue returns a Go expression for a non-default protoreflect.Value that
+// suits the field.  Values are chosen to expose lossy mappings, such as int64
+// values which a float based JSON decoder cannot represent.
+func (idx *descriptorIndex) sampleValue(fd *descriptorpb.FieldDescriptorProto) string {
+	switch fd.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		return fmt.Sprintf("protoreflect.ValueOfString(%q)", "sample_"+fd.GetName())
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		return "protoreflect.ValueOfBytes([]byte{0x00, 0x01, 0xfe, 0xff})"
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		return "protoreflect.ValueOfBool(true)"
+	case descriptorpb.FieldDescriptorProto_TYPE_INT32,
+		descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		return "protoreflect.ValueOfInt32(-2147483648)"
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+		descriptorpb.FieldDescriptorProto_TYPE_FIXED32:
+		return "protoreflect.ValueOfUint32(4294967295)"
+	case descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		// 2^53+1 is the smallest integer a float64 cannot hold.
+		return "protoreflect.ValueOfInt64(-9007199254740993)"
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+		descriptorpb.FieldDescriptorProto_TYPE_FIXED64:
+		return "protoreflect.ValueOfUint64(18446744073709551615)"
+	case descriptorpb.FieldDescriptorProto_TYPE_FLOAT:
+		return "protoreflect.ValueOfFloat32(1.5)"
+	case descriptorpb.FieldDescriptorProto_TYPE_DOUBLE:
+		return "protoreflect.ValueOfFloat64(-0.125)"
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		return fmt.Sprintf("protoreflect.ValueOfEnum(%d)", idx.sampleEnumNumber(fd.GetTypeName()))
+	}
+	return "protoreflect.Value{}"
+}
+
+// sampleEnumNumber returns the first non-zero value of the enum, falling back to
+// the first declared value.
+func (idx *descriptorIndex) sampleEnumNumber(fqn string) int32 {
+	ed, ok := idx.enums[fqn]
+	if !ok || len(ed.GetValue()) == 0 {
+		return 0
+	}
+	for _, v := range ed.GetValue() {
+		if v.GetNumber() != 0 {
+			return v.GetNumber()
+		}
+	}
+	return ed.GetValue()[0].GetNumber()
+}
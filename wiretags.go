@@ -0,0 +1,332 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// wireTagMessage is the wire tag reference for a single message.
+type wireTagMessage struct {
+	Name     string `json:"name"`
+	File     string `json:"file"`
+	MapEntry bool   `json:"mapEntry,omitempty"`
+	// External is set for messages outside of the files to generate, which are
+	// only listed for the extensions the files to generate declare on them.
+	External bool           `json:"external,omitempty"`
+	Fields   []wireTagField `json:"fields"`
+}
+
+// wireTagField describes the tag bytes which precede a field on the wire.
+// Repeated scalar fields may be encoded either packed or unpacked, so both
+// variants are reported.
+type wireTagField struct {
+	Name         string `json:"name"`
+	Number       int32  `json:"number"`
+	Type         string `json:"type"`
+	Repeated     bool   `json:"repeated,omitempty"`
+	WireType     int    `json:"wireType"`
+	WireTypeName string `json:"wireTypeName"`
+	Tag          string `json:"tag"`
+	// EndTag is only set for groups, which are terminated by an END_GROUP tag.
+	EndTag string `json:"endTag,omitempty"`
+	// PackedTag and UnpackedTag are only set for repeated scalar fields.
+	PackedTag       string `json:"packedTag,omitempty"`
+	UnpackedTag     string `json:"unpackedTag,omitempty"`
+	PackedByDefault bool   `json:"packedByDefault,omitempty"`
+	// Extension is set for extension fields, which are named as in text format,
+	// e.g. "[pkg.ext]", and DeclaredIn holds the file declaring them.
+	Extension  bool   `json:"extension,omitempty"`
+	DeclaredIn string `json:"declaredIn,omitempty"`
+}
+
+// generateWireTags produces a reference of the precomputed tag bytes for every
+// field in the files to generate, as both Markdown and JSON documents.
+// Extensions are encoded within their extendee, so they are listed alongside its
+// fields.  Extendees outside of the files to generate are listed with just the
+// extensions declared by the files to generate.
+func generateWireTags(req *pluginpb.CodeGeneratorRequest) ([]*pluginpb.CodeGeneratorResponse_File, error) {
+	idx := newDescriptorIndex(req)
+
+	byExtendee := map[string][]*extensionInfo{}
+	for _, f := range req.GetProtoFile() {
+		prefix := packagePrefix(f)
+		collectExtensions(f.GetExtension(), f.GetName(), prefix, byExtendee)
+		for _, m := range f.GetMessageType() {
+			for _, mi := range idx.localMessages(m, prefix, prefix) {
+				collectExtensions(mi.desc.GetExtension(), f.GetName(), mi.fqn, byExtendee)
+			}
+		}
+	}
+	for _, exts := range byExtendee {
+		sort.SliceStable(exts, func(i, j int) bool {
+			return exts[i].desc.GetNumber() < exts[j].desc.GetNumber()
+		})
+	}
+
+	var messages []wireTagMessage
+	listed := map[string]bool{}
+	generated := map[string]bool{}
+	for _, name := range req.GetFileToGenerate() {
+		f, ok := idx.files[name]
+		if !ok {
+			return nil, fmt.Errorf("file to generate %q not present in request", name)
+		}
+		generated[name] = true
+		for _, m := range f.GetMessageType() {
+			for _, mi := range idx.localMessages(m, packagePrefix(f), packagePrefix(f)) {
+				listed[mi.fqn] = true
+				messages = append(messages, computeWireTags(idx, f, mi, byExtendee[mi.fqn]))
+			}
+		}
+	}
+
+	var external []string
+	for extendee, exts := range byExtendee {
+		if listed[extendee] {
+			continue
+		}
+		for _, x := range exts {
+			if generated[x.file] {
+				external = append(external, extendee)
+				break
+			}
+		}
+	}
+	sort.Strings(external)
+	for _, extendee := range external {
+		mi, ok := idx.messages[extendee]
+		if !ok {
+			continue
+		}
+		var exts []*extensionInfo
+		for _, x := range byExtendee[extendee] {
+			if generated[x.file] {
+				exts = append(exts, x)
+			}
+		}
+		messages = append(messages, wireTagMessage{
+			Name:     strings.TrimPrefix(extendee, "."),
+			File:     mi.file.GetName(),
+			External: true,
+			Fields:   extensionWireTags(idx, exts),
+		})
+	}
+
+	jsonBytes, err := json.MarshalIndent(struct {
+		Messages []wireTagMessage `json:"messages"`
+	}{messages}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("json.MarshalIndent: %w", err)
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "# wire tag reference")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "Tag bytes are the varint encoding of `(field_number << 3) | wire_type`, shown in hex.")
+	for _, m := range messages {
+		fmt.Fprintf(buf, "\n## %s\n\n", m.Name)
+		fmt.Fprintf(buf, "Declared in `%s`.", m.File)
+		if m.MapEntry {
+			fmt.Fprint(buf, " Synthetic map entry message.")
+		}
+		if m.External {
+			fmt.Fprint(buf, " Only extensions declared in the files to generate are listed.")
+		}
+		fmt.Fprintln(buf)
+		if len(m.Fields) == 0 {
+			fmt.Fprintln(buf, "\nNo fields.")
+			continue
+		}
+		fmt.Fprintln(buf)
+		fmt.Fprintln(buf, "| field | number | type | wire type | tag bytes | notes |")
+		fmt.Fprintln(buf, "|---|---|---|---|---|---|")
+		for _, fld := range m.Fields {
+			var notes []string
+			if fld.Extension {
+				notes = append(notes, fmt.Sprintf("extension declared in `%s`", fld.DeclaredIn))
+			}
+			if fld.EndTag != "" {
+				notes = append(notes, fmt.Sprintf("group ends with `%s`", fld.EndTag))
+			}
+			if fld.PackedTag != "" {
+				def := "unpacked"
+				if fld.PackedByDefault {
+					def = "packed"
+				}
+				notes = append(notes, fmt.Sprintf("packed `%s`, unpacked `%s`, %s by default", fld.PackedTag, fld.UnpackedTag, def))
+			}
+			typ := fld.Type
+			if fld.Repeated {
+				typ = "repeated " + typ
+			}
+			fmt.Fprintf(buf, "| %s | %d | %s | %d (%s) | `%s` | %s |\n",
+				fld.Name, fld.Number, typ, fld.WireType, fld.WireTypeName, fld.Tag, strings.Join(notes, "; "))
+		}
+	}
+
+	return []*pluginpb.CodeGeneratorResponse_File{
+		{
+			Name:    proto.String("wire_tags.md"),
+			Content: proto.String(buf.String()),
+		},
+		{
+			Name:    proto.String("wire_tags.json"),
+			Content: proto.String(string(jsonBytes) + "\n"),
+		},
+	}, nil
+}
+
+// computeWireTags builds the wire tag reference for a single message, followed by
+// the given extensions of it.
+func computeWireTags(idx *descriptorIndex, f *descriptorpb.FileDescriptorProto, mi *messageInfo, exts []*extensionInfo) wireTagMessage {
+	out := wireTagMessage{
+		Name:     strings.TrimPrefix(mi.fqn, "."),
+		File:     f.GetName(),
+		MapEntry: mi.desc.GetOptions().GetMapEntry(),
+		Fields:   []wireTagField{},
+	}
+	for _, fd := range mi.desc.GetField() {
+		out.Fields = append(out.Fields, computeWireTagField(f, fd))
+	}
+	out.Fields = append(out.Fields, extensionWireTags(idx, exts)...)
+	return out
+}
+
+// extensionWireTags builds the wire tag reference for extensions, which are
+// named as in text format.
+func extensionWireTags(idx *descriptorIndex, exts []*extensionInfo) []wireTagField {
+	var out []wireTagField
+	for _, x := range exts {
+		// packing depends on the syntax of the file declaring the extension.
+		fld := computeWireTagField(idx.files[x.file], x.desc)
+		fld.Name = "[" + x.name + "]"
+		fld.Extension = true
+		fld.DeclaredIn = x.file
+		out = append(out, fld)
+	}
+	return out
+}
+
+// computeWireTagField builds the wire tag reference for a single field declared in f.
+func computeWireTagField(f *descriptorpb.FileDescriptorProto, fd *descriptorpb.FieldDescriptorProto) wireTagField {
+	num := protowire.Number(fd.GetNumber())
+	wt := fieldWireType(fd.GetType())
+	fld := wireTagField{
+		Name:         fd.GetName(),
+		Number:       fd.GetNumber(),
+		Type:         fieldTypeName(fd),
+		Repeated:     fd.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED,
+		WireType:     int(wt),
+		WireTypeName: wireTypeName(wt),
+		Tag:          tagHex(num, wt),
+	}
+	if wt == protowire.StartGroupType {
+		fld.EndTag = tagHex(num, protowire.EndGroupType)
+	}
+	if fld.Repeated && isPackable(fd.GetType()) {
+		fld.PackedTag = tagHex(num, protowire.BytesType)
+		fld.UnpackedTag = tagHex(num, wt)
+		fld.PackedByDefault = isPackedByDefault(f, fd)
+		if fld.PackedByDefault {
+			// report the encoding a conforming writer emits.
+			fld.WireType = int(protowire.BytesType)
+			fld.WireTypeName = wireTypeName(protowire.BytesType)
+			fld.Tag = fld.PackedTag
+		}
+	}
+	return fld
+}
+
+// tagHex renders the varint encoded tag for a field number and wire type, e.g. "0x0a".
+func tagHex(num protowire.Number, wt protowire.Type) string {
+	var parts []string
+	for _, b := range protowire.AppendTag(nil, num, wt) {
+		parts = append(parts, fmt.Sprintf("0x%02x", b))
+	}
+	return strings.Join(parts, " ")
+}
+
+// fieldWireType returns the wire type used for a single (unpacked) value of the field type.
+func fieldWireType(t descriptorpb.FieldDescriptorProto_Type) protowire.Type {
+	switch t {
+	case descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+		descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		return protowire.Fixed64Type
+	case descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+		descriptorpb.FieldDescriptorProto_TYPE_FIXED32,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		return protowire.Fixed32Type
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+		descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+		return protowire.BytesType
+	case descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		return protowire.StartGroupType
+	}
+	return protowire.VarintType
+}
+
+// wireTypeName returns the name the protobuf encoding documentation uses for a wire type.
+func wireTypeName(wt protowire.Type) string {
+	switch wt {
+	case protowire.VarintType:
+		return "VARINT"
+	case protowire.Fixed64Type:
+		return "I64"
+	case protowire.BytesType:
+		return "LEN"
+	case protowire.StartGroupType:
+		return "SGROUP"
+	case protowire.EndGroupType:
+		return "EGROUP"
+	case protowire.Fixed32Type:
+		return "I32"
+	}
+	return "UNKNOWN"
+}
+
+// fieldTypeName returns the type of the field as it would be written in a .proto file.
+func fieldTypeName(fd *descriptorpb.FieldDescriptorProto) string {
+	if fd.GetTypeName() != "" {
+		return strings.TrimPrefix(fd.GetTypeName(), ".")
+	}
+	return strings.ToLower(strings.TrimPrefix(fd.GetType().String(), "TYPE_"))
+}
+
+// isPackable reports whether repeated fields of this type may use packed encoding.
+func isPackable(t descriptorpb.FieldDescriptorProto_Type) bool {
+	return fieldWireType(t) != protowire.BytesType && fieldWireType(t) != protowire.StartGroupType
+}
+
+// isPackedByDefault reports whether a writer emits the repeated scalar field packed.
+// Only proto2 and proto3 files are considered, as the plugin does not advertise
+// support for editions.
+func isPackedByDefault(f *descriptorpb.FileDescriptorProto, fd *descriptorpb.FieldDescriptorProto) bool {
+	if fd.GetOptions() != nil && fd.GetOptions().Packed != nil {
+		return fd.GetOptions().GetPacked()
+	}
+	return f.GetSyntax() == "proto3"
+}